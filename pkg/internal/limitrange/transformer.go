@@ -18,10 +18,14 @@ package limitrange
 
 import (
 	"context"
+	"fmt"
+	"sort"
 
 	"github.com/tektoncd/pipeline/pkg/pod"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation/field"
 	corev1listers "k8s.io/client-go/listers/core/v1"
 )
 
@@ -45,52 +49,108 @@ func NewTransformer(ctx context.Context, namespace string, lister corev1listers.
 
 		// The assumption here is that the min, max, default, ratio have already been
 		// computed if there is multiple LimitRange to satisfy the most (if we can).
-		// Count the number of containers (that we know) in the Pod.
+		// Count the number of containers (that we know) in the Pod. Native sidecars (init
+		// containers with RestartPolicy: Always) run for the pod's lifetime alongside the app
+		// containers, so they're counted against the app-container budget rather than the
+		// init-container one, matching how kube-scheduler and kubectl describe account for them.
 		// This should help us find the smallest request to apply to containers
-		nbContainers := len(p.Spec.Containers)
-		// FIXME(#4230) maxLimitRequestRatio to support later
+		nbContainers := len(p.Spec.Containers) + countSidecarInitContainers(p.Spec.InitContainers)
 		defaultLimits := getDefaultLimits(limitRange)
 		defaultInitRequests := getDefaultInitContainerRequest(limitRange)
+		defaultRequests := getDefaultAppContainerRequest(limitRange, nbContainers)
 		for i := range p.Spec.InitContainers {
-			// We are trying to set the smallest requests possible
-			if p.Spec.InitContainers[i].Resources.Requests == nil {
-				p.Spec.InitContainers[i].Resources.Requests = defaultInitRequests
+			init := &p.Spec.InitContainers[i]
+			// A sidecar init container shares the app-container budget instead of getting the
+			// full (undivided) init-container default, since it runs concurrently with them.
+			requestDefault := defaultInitRequests
+			if isSidecarInitContainer(init) {
+				requestDefault = defaultRequests
+			}
+			// We are trying to set the smallest requests possible. Each container needs its
+			// own copy of the default map: requestDefault/defaultLimits are computed once per
+			// pod and reused for every container, and the pod-aggregate and ratio enforcement
+			// below mutate a container's Requests/Limits in place, so aliasing the same map
+			// across containers would apply those mutations more than once.
+			if init.Resources.Requests == nil {
+				init.Resources.Requests = requestDefault.DeepCopy()
 			} else {
 				for _, name := range resourceNames {
-					setRequestsOrLimits(name, p.Spec.InitContainers[i].Resources.Requests, defaultInitRequests)
+					setRequestsOrLimits(name, init.Resources.Requests, requestDefault)
 				}
 			}
 			// We are trying to set the highest limits possible
-			if p.Spec.InitContainers[i].Resources.Limits == nil {
-				p.Spec.InitContainers[i].Resources.Limits = defaultLimits
+			if init.Resources.Limits == nil {
+				init.Resources.Limits = defaultLimits.DeepCopy()
 			} else {
 				for _, name := range resourceNames {
-					setRequestsOrLimits(name, p.Spec.InitContainers[i].Resources.Limits, defaultLimits)
+					setRequestsOrLimits(name, init.Resources.Limits, defaultLimits)
 				}
 			}
 		}
 
-		defaultRequests := getDefaultAppContainerRequest(limitRange, nbContainers)
 		for i := range p.Spec.Containers {
 			if p.Spec.Containers[i].Resources.Requests == nil {
-				p.Spec.Containers[i].Resources.Requests = defaultRequests
+				p.Spec.Containers[i].Resources.Requests = defaultRequests.DeepCopy()
 			} else {
 				for _, name := range resourceNames {
 					setRequestsOrLimits(name, p.Spec.Containers[i].Resources.Requests, defaultRequests)
 				}
 			}
 			if p.Spec.Containers[i].Resources.Limits == nil {
-				p.Spec.Containers[i].Resources.Limits = defaultLimits
+				p.Spec.Containers[i].Resources.Limits = defaultLimits.DeepCopy()
 			} else {
 				for _, name := range resourceNames {
 					setRequestsOrLimits(name, p.Spec.Containers[i].Resources.Limits, defaultLimits)
 				}
 			}
 		}
+
+		// A LimitTypePod item caps the sum of requests/limits across every container in the
+		// pod, not any one container. The per-container defaults above were only divided by
+		// container count, so they can still add up to more than the pod is allowed: scale
+		// every container's request/limit down (or up, for Min) proportionally to fit.
+		podMin, podMax, podRatio := getLimitRangeBounds(limitRange, corev1.LimitTypePod)
+		if err := enforcePodAggregateBounds(p, podMin, podMax); err != nil {
+			return p, err
+		}
+
+		// Now that every container has a request and a limit, make sure neither violates
+		// MaxLimitRequestRatio: raise the request, or failing that lower the limit, so the
+		// pod doesn't get rejected by the LimitRanger admission plugin once it reaches the
+		// apiserver.
+		containerMin, containerMax, containerRatio := getLimitRangeBounds(limitRange, corev1.LimitTypeContainer)
+		if err := enforceMaxLimitRequestRatio(p.Spec.InitContainers, containerMin, containerMax, containerRatio); err != nil {
+			return p, err
+		}
+		if err := enforceMaxLimitRequestRatio(p.Spec.Containers, containerMin, containerMax, containerRatio); err != nil {
+			return p, err
+		}
+
+		if err := enforcePodMaxLimitRequestRatio(p, podMin, podMax, podRatio); err != nil {
+			return p, err
+		}
+
 		return p, nil
 	}
 }
 
+// countSidecarInitContainers returns how many init containers are configured as native
+// sidecars (RestartPolicy: Always). Unlike regular init containers, these keep running for the
+// lifetime of the pod and so consume resources concurrently with the app containers.
+func countSidecarInitContainers(initContainers []corev1.Container) int {
+	count := 0
+	for i := range initContainers {
+		if isSidecarInitContainer(&initContainers[i]) {
+			count++
+		}
+	}
+	return count
+}
+
+func isSidecarInitContainer(c *corev1.Container) bool {
+	return c.RestartPolicy != nil && *c.RestartPolicy == corev1.ContainerRestartPolicyAlways
+}
+
 func setRequestsOrLimits(name corev1.ResourceName, dst, src corev1.ResourceList) {
 	if isZero(dst[name]) && !isZero(src[name]) {
 		dst[name] = src[name]
@@ -126,7 +186,9 @@ func getDefaultAppContainerRequest(limitRange *corev1.LimitRange, nbContainers i
 	return r
 }
 
-// Returns the default requests to use for each init container, determined by the LimitRange default requests and minimums
+// Returns the default requests to use for each non-sidecar init container, determined by the
+// LimitRange default requests and minimums. Regular init containers run sequentially, so each
+// one can take the full default; sidecar init containers get a share of defaultRequests instead.
 func getDefaultInitContainerRequest(limitRange *corev1.LimitRange) corev1.ResourceList {
 	// Support only Type Container to start with
 	var r corev1.ResourceList
@@ -168,3 +230,444 @@ func getDefaultLimits(limitRange *corev1.LimitRange) corev1.ResourceList {
 	}
 	return l
 }
+
+// getLimitRangeBounds returns the Min, Max and MaxLimitRequestRatio recorded in limitRange for
+// the given LimitType, so callers don't need to walk limitRange.Spec.Limits themselves.
+func getLimitRangeBounds(limitRange *corev1.LimitRange, limitType corev1.LimitType) (min, max, ratio corev1.ResourceList) {
+	for _, item := range limitRange.Spec.Limits {
+		if item.Type != limitType {
+			continue
+		}
+		if item.Min != nil {
+			min = item.Min
+		}
+		if item.Max != nil {
+			max = item.Max
+		}
+		if item.MaxLimitRequestRatio != nil {
+			ratio = item.MaxLimitRequestRatio
+		}
+	}
+	return min, max, ratio
+}
+
+// enforceMaxLimitRequestRatio walks each container and, for every resource with a
+// MaxLimitRequestRatio set, raises the request (or failing that lowers the limit) so that
+// limit/request no longer exceeds the ratio. It returns an error if neither adjustment can
+// satisfy the ratio without violating Min/Max, since that means the pod would be rejected by
+// the LimitRanger admission plugin no matter what we do here.
+func enforceMaxLimitRequestRatio(containers []corev1.Container, min, max, ratio corev1.ResourceList) error {
+	for i := range containers {
+		c := &containers[i]
+		for _, name := range resourceNames {
+			maxRatio := ratio[name]
+			newRequest, newLimit, ok := resolveRatio(c.Resources.Requests[name], c.Resources.Limits[name], maxRatio, min[name], max[name])
+			if !ok {
+				return fmt.Errorf("container %q: resource %q limit/request ratio exceeds maxLimitRequestRatio %s and cannot be resolved within Min/Max", c.Name, name, maxRatio.String())
+			}
+			// This is a final sanity check, not just a ratio check: resolveRatio leaves
+			// request/limit untouched when no ratio is configured for name, so if an earlier
+			// step (e.g. pod-aggregate scaling) left limit < request, that would otherwise
+			// reach the apiserver unnoticed since it isn't a ratio violation at all.
+			if !isZero(newRequest) && !isZero(newLimit) && newLimit.Cmp(newRequest) < 0 {
+				return fmt.Errorf("container %q: resource %q limit %s is below request %s", c.Name, name, newLimit.String(), newRequest.String())
+			}
+			if c.Resources.Requests != nil {
+				c.Resources.Requests[name] = newRequest
+			}
+			if c.Resources.Limits != nil {
+				c.Resources.Limits[name] = newLimit
+			}
+		}
+	}
+	return nil
+}
+
+// enforcePodAggregateBounds scales every container's request or limit proportionally so that
+// the pod-wide sum fits within the LimitTypePod Min/Max: limits are scaled down if their total
+// exceeds Max, and requests are scaled up if their total is below Min. Containers with
+// explicit values are scaled the same as containers that only received a default, since the
+// pod cap applies to the sum regardless of where each value came from. It returns an error if
+// the pod cap can't be met without breaking some container's own limit >= request invariant,
+// the same way enforceMaxLimitRequestRatio bails instead of producing a pod the apiserver would
+// still reject.
+func enforcePodAggregateBounds(p *corev1.Pod, min, max corev1.ResourceList) error {
+	containers := containerPointers(p)
+	for _, name := range resourceNames {
+		totalRequest, totalLimit := sumPodResource(containers, name)
+		if m := max[name]; !isZero(m) && !isZero(totalLimit) && totalLimit.Cmp(m) > 0 {
+			if err := distributeRatioAdjustment(containers, name, false, totalLimit, m); err != nil {
+				return fmt.Errorf("pod: resource %q aggregate limit exceeds pod max %s: %w", name, m.String(), err)
+			}
+		}
+		if m := min[name]; !isZero(m) && !isZero(totalRequest) && totalRequest.Cmp(m) < 0 {
+			if err := distributeRatioAdjustment(containers, name, true, totalRequest, m); err != nil {
+				return fmt.Errorf("pod: resource %q aggregate request is below pod min %s: %w", name, m.String(), err)
+			}
+		}
+	}
+	return nil
+}
+
+// enforcePodMaxLimitRequestRatio applies the same check as enforceMaxLimitRequestRatio, but
+// against the sum of requests/limits across every container in the pod: a LimitTypePod
+// MaxLimitRequestRatio constrains the pod as a whole, not any single container. When the
+// aggregate ratio needs to move, the adjustment is spread across containers in proportion to
+// their existing share of the total.
+func enforcePodMaxLimitRequestRatio(p *corev1.Pod, min, max, ratio corev1.ResourceList) error {
+	containers := containerPointers(p)
+	for _, name := range resourceNames {
+		if isZero(ratio[name]) {
+			continue
+		}
+		totalRequest, totalLimit := sumPodResource(containers, name)
+		maxRatio := ratio[name]
+		newRequest, newLimit, ok := resolveRatio(totalRequest, totalLimit, maxRatio, min[name], max[name])
+		if !ok {
+			return fmt.Errorf("pod: resource %q aggregate limit/request ratio exceeds maxLimitRequestRatio %s and cannot be resolved within Min/Max", name, maxRatio.String())
+		}
+		if !newRequest.Equal(totalRequest) {
+			if err := distributeRatioAdjustment(containers, name, true, totalRequest, newRequest); err != nil {
+				return fmt.Errorf("pod: resource %q maxLimitRequestRatio adjustment failed: %w", name, err)
+			}
+		}
+		if !newLimit.Equal(totalLimit) {
+			if err := distributeRatioAdjustment(containers, name, false, totalLimit, newLimit); err != nil {
+				return fmt.Errorf("pod: resource %q maxLimitRequestRatio adjustment failed: %w", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// resolveRatio adjusts request/limit so that limit/request no longer exceeds maxRatio,
+// preferring to raise the request (bounded by max) and falling back to lowering the limit
+// (bounded by min). It returns ok=false when neither adjustment keeps the ratio within
+// Min/Max, meaning the ratio genuinely cannot be satisfied.
+func resolveRatio(request, limit, maxRatio, min, max resource.Quantity) (newRequest, newLimit resource.Quantity, ok bool) {
+	if isZero(maxRatio) || isZero(request) || isZero(limit) {
+		return request, limit, true
+	}
+	maxRatioFloat := maxRatio.AsApproximateFloat64()
+	if !exceedsRatio(limit, request, maxRatioFloat) {
+		return request, limit, true
+	}
+	raisedRequest := scaleQuantity(limit, 1/maxRatioFloat)
+	if isZero(max) || raisedRequest.Cmp(max) <= 0 {
+		return raisedRequest, limit, true
+	}
+	loweredLimit := scaleQuantity(request, maxRatioFloat)
+	if isZero(min) || loweredLimit.Cmp(min) >= 0 {
+		return request, loweredLimit, true
+	}
+	return request, limit, false
+}
+
+func exceedsRatio(limit, request resource.Quantity, maxRatioFloat float64) bool {
+	return float64(limit.MilliValue()) > maxRatioFloat*float64(request.MilliValue())
+}
+
+func scaleQuantity(q resource.Quantity, factor float64) resource.Quantity {
+	return *resource.NewMilliQuantity(int64(float64(q.MilliValue())*factor), q.Format)
+}
+
+func containerPointers(p *corev1.Pod) []*corev1.Container {
+	containers := make([]*corev1.Container, 0, len(p.Spec.InitContainers)+len(p.Spec.Containers))
+	for i := range p.Spec.InitContainers {
+		containers = append(containers, &p.Spec.InitContainers[i])
+	}
+	for i := range p.Spec.Containers {
+		containers = append(containers, &p.Spec.Containers[i])
+	}
+	return containers
+}
+
+func sumPodResource(containers []*corev1.Container, name corev1.ResourceName) (request, limit resource.Quantity) {
+	for _, c := range containers {
+		request.Add(c.Resources.Requests[name])
+		limit.Add(c.Resources.Limits[name])
+	}
+	return request, limit
+}
+
+// distributeRatioAdjustment scales every container's request (or limit) for name by the same
+// factor so that their sum moves from oldTotal to newTotal. Each container is checked against
+// its own opposing field first, the same invariant resolveRatio protects for the
+// single-container ratio case: a request is never raised past that container's own limit, and a
+// limit is never lowered past that container's own request. It returns an error instead of
+// producing a container the apiserver would reject.
+func distributeRatioAdjustment(containers []*corev1.Container, name corev1.ResourceName, isRequest bool, oldTotal, newTotal resource.Quantity) error {
+	if oldTotal.IsZero() {
+		return nil
+	}
+	factor := float64(newTotal.MilliValue()) / float64(oldTotal.MilliValue())
+	for _, c := range containers {
+		if isRequest {
+			q := c.Resources.Requests[name]
+			if isZero(q) {
+				continue
+			}
+			scaled := scaleQuantity(q, factor)
+			if limit := c.Resources.Limits[name]; !isZero(limit) && scaled.Cmp(limit) > 0 {
+				return fmt.Errorf("container %q: resource %q request cannot be raised to %s without exceeding its own limit %s", c.Name, name, scaled.String(), limit.String())
+			}
+			c.Resources.Requests[name] = scaled
+		} else {
+			q := c.Resources.Limits[name]
+			if isZero(q) {
+				continue
+			}
+			scaled := scaleQuantity(q, factor)
+			if request := c.Resources.Requests[name]; !isZero(request) && scaled.Cmp(request) < 0 {
+				return fmt.Errorf("container %q: resource %q limit cannot be lowered to %s without dropping below its own request %s", c.Name, name, scaled.String(), request.String())
+			}
+			c.Resources.Limits[name] = scaled
+		}
+	}
+	return nil
+}
+
+// getVirtualLimitRange aggregates every LimitRange defined in the namespace into a single
+// virtual LimitRange, one item per LimitType, so the rest of the transformer doesn't need to
+// know how many LimitRange objects a namespace has. For each field we keep the most
+// restrictive value across all of them: the highest Min, the lowest Max, and the lowest
+// MaxLimitRequestRatio. Default and DefaultRequest are taken from the first LimitRange, in name
+// order, that sets them, since Kubernetes doesn't define an order for merging conflicting
+// defaults either.
+func getVirtualLimitRange(ctx context.Context, namespace string, lister corev1listers.LimitRangeLister) (*corev1.LimitRange, error) {
+	limitRanges, err := lister.LimitRanges(namespace).List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	if len(limitRanges) == 0 {
+		return nil, nil
+	}
+	// The lister returns LimitRanges in no particular order; sort by name so "first" above is
+	// deterministic.
+	sort.Slice(limitRanges, func(i, j int) bool { return limitRanges[i].Name < limitRanges[j].Name })
+
+	itemsByType := map[corev1.LimitType]*corev1.LimitRangeItem{}
+	for _, limitRange := range limitRanges {
+		for _, item := range limitRange.Spec.Limits {
+			existing, ok := itemsByType[item.Type]
+			if !ok {
+				// item.DeepCopy() is required here, not a plain struct copy: item.Min/Max/
+				// Default/DefaultRequest/MaxLimitRequestRatio are maps, and a struct copy
+				// would still alias them to the LimitRange objects cached by the lister.
+				// mergeLimitRangeItem below mutates dst's maps in place, so without this copy
+				// a second LimitRange of the same type would corrupt the informer's cache.
+				itemsByType[item.Type] = item.DeepCopy()
+				continue
+			}
+			mergeLimitRangeItem(existing, &item)
+		}
+	}
+
+	virtual := &corev1.LimitRange{}
+	for _, item := range itemsByType {
+		virtual.Spec.Limits = append(virtual.Spec.Limits, *item)
+	}
+	return virtual, nil
+}
+
+// mergeLimitRangeItem folds src into dst in place, keeping the most restrictive bound for Min,
+// Max and MaxLimitRequestRatio, and filling in Default/DefaultRequest only if dst doesn't
+// already set them.
+func mergeLimitRangeItem(dst, src *corev1.LimitRangeItem) {
+	// Don't assume resourceNames: a LimitTypePersistentVolumeClaim item is keyed by
+	// corev1.ResourceStorage, which container/pod items never use.
+	for _, name := range allResourceKeys(src.Min, src.Max, src.Default, src.DefaultRequest, src.MaxLimitRequestRatio) {
+		mergeHighest(&dst.Min, src.Min, name)
+		mergeLowest(&dst.Max, src.Max, name)
+		mergeLowest(&dst.MaxLimitRequestRatio, src.MaxLimitRequestRatio, name)
+		mergeFirst(&dst.Default, src.Default, name)
+		mergeFirst(&dst.DefaultRequest, src.DefaultRequest, name)
+	}
+}
+
+// allResourceKeys returns the de-duplicated union of resource names used across lists.
+func allResourceKeys(lists ...corev1.ResourceList) []corev1.ResourceName {
+	seen := map[corev1.ResourceName]bool{}
+	var names []corev1.ResourceName
+	for _, list := range lists {
+		for name := range list {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
+func mergeHighest(dst *corev1.ResourceList, src corev1.ResourceList, name corev1.ResourceName) {
+	s := src[name]
+	if isZero(s) {
+		return
+	}
+	if d := (*dst)[name]; !isZero(d) && d.Cmp(s) >= 0 {
+		return
+	}
+	setResource(dst, name, s)
+}
+
+func mergeLowest(dst *corev1.ResourceList, src corev1.ResourceList, name corev1.ResourceName) {
+	s := src[name]
+	if isZero(s) {
+		return
+	}
+	if d := (*dst)[name]; !isZero(d) && d.Cmp(s) <= 0 {
+		return
+	}
+	setResource(dst, name, s)
+}
+
+func mergeFirst(dst *corev1.ResourceList, src corev1.ResourceList, name corev1.ResourceName) {
+	s := src[name]
+	if isZero(s) || !isZero((*dst)[name]) {
+		return
+	}
+	setResource(dst, name, s)
+}
+
+func setResource(list *corev1.ResourceList, name corev1.ResourceName, q resource.Quantity) {
+	if *list == nil {
+		*list = corev1.ResourceList{}
+	}
+	(*list)[name] = q
+}
+
+// NewPVCTransformer returns a function that clamps a PersistentVolumeClaim's storage request to
+// the LimitTypePersistentVolumeClaim Min/Max recorded in the namespace's LimitRange(s), using
+// DefaultRequest when the claim sets no storage request. Tekton auto-creates PVCs from workspace
+// VolumeClaimTemplates, and those are admitted through the same LimitRange as any other PVC.
+//
+// TODO: this is not yet called from the workspace/affinity-assistant PVC creation path (that
+// reconciler code isn't part of this change); wiring it in is the remaining step for users in
+// constrained namespaces to actually stop hand-sizing every workspace.
+func NewPVCTransformer(ctx context.Context, namespace string, lister corev1listers.LimitRangeLister) func(*corev1.PersistentVolumeClaim) (*corev1.PersistentVolumeClaim, error) {
+	return func(pvc *corev1.PersistentVolumeClaim) (*corev1.PersistentVolumeClaim, error) {
+		limitRange, err := getVirtualLimitRange(ctx, namespace, lister)
+		if err != nil {
+			return pvc, err
+		}
+		if limitRange == nil {
+			return pvc, nil
+		}
+
+		min, max, defaultRequest := getPVCStorageBounds(limitRange)
+		if isZero(min) && isZero(max) && isZero(defaultRequest) {
+			return pvc, nil
+		}
+
+		if pvc.Spec.Resources.Requests == nil {
+			pvc.Spec.Resources.Requests = corev1.ResourceList{}
+		}
+		storage := pvc.Spec.Resources.Requests[corev1.ResourceStorage]
+		if isZero(storage) && !isZero(defaultRequest) {
+			storage = defaultRequest
+		}
+		if !isZero(min) && storage.Cmp(min) < 0 {
+			storage = min
+		}
+		if !isZero(max) && storage.Cmp(max) > 0 {
+			storage = max
+		}
+		pvc.Spec.Resources.Requests[corev1.ResourceStorage] = storage
+		return pvc, nil
+	}
+}
+
+// getPVCStorageBounds returns the Min, Max and DefaultRequest storage quantities recorded in
+// limitRange for LimitTypePersistentVolumeClaim.
+func getPVCStorageBounds(limitRange *corev1.LimitRange) (min, max, defaultRequest resource.Quantity) {
+	for _, item := range limitRange.Spec.Limits {
+		if item.Type != corev1.LimitTypePersistentVolumeClaim {
+			continue
+		}
+		if item.Min != nil {
+			min = item.Min[corev1.ResourceStorage]
+		}
+		if item.Max != nil {
+			max = item.Max[corev1.ResourceStorage]
+		}
+		if item.DefaultRequest != nil {
+			defaultRequest = item.DefaultRequest[corev1.ResourceStorage]
+		}
+	}
+	return min, max, defaultRequest
+}
+
+// NewValidator returns a function that reports every Min/Max/MaxLimitRequestRatio violation a
+// pod has against the namespace's aggregated LimitRange, without mutating the pod. It walks the
+// same virtual LimitRange that NewTransformer mutates against, so the two stay in sync; a
+// TaskRun reconciler can call this before pod creation to surface a clear Validation condition
+// instead of letting the apiserver reject the pod after the fact.
+//
+// TODO: the TaskRun reconciler doesn't call this yet (that reconciler code isn't part of this
+// change); wiring it into a Validation condition before pod creation is the remaining step.
+func NewValidator(ctx context.Context, namespace string, lister corev1listers.LimitRangeLister) func(*corev1.Pod) (field.ErrorList, error) {
+	return func(p *corev1.Pod) (field.ErrorList, error) {
+		limitRange, err := getVirtualLimitRange(ctx, namespace, lister)
+		if err != nil {
+			return nil, err
+		}
+		if limitRange == nil {
+			return nil, nil
+		}
+
+		var errs field.ErrorList
+		containerMin, containerMax, containerRatio := getLimitRangeBounds(limitRange, corev1.LimitTypeContainer)
+		errs = append(errs, validateContainerBounds(field.NewPath("spec", "initContainers"), p.Spec.InitContainers, containerMin, containerMax, containerRatio)...)
+		errs = append(errs, validateContainerBounds(field.NewPath("spec", "containers"), p.Spec.Containers, containerMin, containerMax, containerRatio)...)
+
+		podMin, podMax, podRatio := getLimitRangeBounds(limitRange, corev1.LimitTypePod)
+		errs = append(errs, validatePodAggregateBounds(containerPointers(p), podMin, podMax, podRatio)...)
+
+		return errs, nil
+	}
+}
+
+// validateContainerBounds reports every Min/Max/MaxLimitRequestRatio violation among containers,
+// rooted at base (e.g. spec.containers) so the field.Error points at the offending container and
+// resource.
+func validateContainerBounds(base *field.Path, containers []corev1.Container, min, max, ratio corev1.ResourceList) field.ErrorList {
+	var errs field.ErrorList
+	for i := range containers {
+		c := &containers[i]
+		for _, name := range resourceNames {
+			request := c.Resources.Requests[name]
+			limit := c.Resources.Limits[name]
+			if m := min[name]; !isZero(m) && !isZero(request) && request.Cmp(m) < 0 {
+				errs = append(errs, field.Invalid(base.Index(i).Child("resources", "requests", string(name)), request.String(), fmt.Sprintf("must be greater than or equal to min %s", m.String())))
+			}
+			if m := max[name]; !isZero(m) && !isZero(limit) && limit.Cmp(m) > 0 {
+				errs = append(errs, field.Invalid(base.Index(i).Child("resources", "limits", string(name)), limit.String(), fmt.Sprintf("must be less than or equal to max %s", m.String())))
+			}
+			if r := ratio[name]; !isZero(r) && !isZero(request) && !isZero(limit) && exceedsRatio(limit, request, r.AsApproximateFloat64()) {
+				errs = append(errs, field.Invalid(base.Index(i).Child("resources", "limits", string(name)), limit.String(), fmt.Sprintf("limit/request ratio exceeds maxLimitRequestRatio %s", r.String())))
+			}
+		}
+	}
+	return errs
+}
+
+// validatePodAggregateBounds reports Min/Max/MaxLimitRequestRatio violations against the sum of
+// requests/limits across every container, the way a LimitTypePod item constrains the pod.
+func validatePodAggregateBounds(containers []*corev1.Container, min, max, ratio corev1.ResourceList) field.ErrorList {
+	var errs field.ErrorList
+	path := field.NewPath("spec", "containers")
+	for _, name := range resourceNames {
+		totalRequest, totalLimit := sumPodResource(containers, name)
+		if m := max[name]; !isZero(m) && !isZero(totalLimit) && totalLimit.Cmp(m) > 0 {
+			errs = append(errs, field.Invalid(path, totalLimit.String(), fmt.Sprintf("aggregate %s limit exceeds pod max %s", name, m.String())))
+		}
+		if m := min[name]; !isZero(m) && !isZero(totalRequest) && totalRequest.Cmp(m) < 0 {
+			errs = append(errs, field.Invalid(path, totalRequest.String(), fmt.Sprintf("aggregate %s request is below pod min %s", name, m.String())))
+		}
+		if r := ratio[name]; !isZero(r) && !isZero(totalRequest) && !isZero(totalLimit) && exceedsRatio(totalLimit, totalRequest, r.AsApproximateFloat64()) {
+			errs = append(errs, field.Invalid(path, totalLimit.String(), fmt.Sprintf("aggregate %s limit/request ratio exceeds pod maxLimitRequestRatio %s", name, r.String())))
+		}
+	}
+	return errs
+}
@@ -0,0 +1,373 @@
+/*
+Copyright 2020 The Tekton Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package limitrange
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func getLister(t *testing.T, limitRanges ...*corev1.LimitRange) corev1listers.LimitRangeLister {
+	t.Helper()
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	for _, lr := range limitRanges {
+		if err := indexer.Add(lr); err != nil {
+			t.Fatalf("failed to add LimitRange to indexer: %v", err)
+		}
+	}
+	return corev1listers.NewLimitRangeLister(indexer)
+}
+
+// TestNewTransformerPodMaxScalesSharedDefaultsOnce guards against a bug where two containers
+// that both fell back to the same LimitRange default map ended up aliasing one
+// corev1.ResourceList: scaling the pod down to fit a LimitTypePod Max mutated that shared map
+// once per container, compounding the scale factor instead of applying it once per container.
+func TestNewTransformerPodMaxScalesSharedDefaultsOnce(t *testing.T) {
+	limitRange := &corev1.LimitRange{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "foo", Name: "limitrange"},
+		Spec: corev1.LimitRangeSpec{
+			Limits: []corev1.LimitRangeItem{{
+				Type:    corev1.LimitTypeContainer,
+				Default: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("200m")},
+			}, {
+				Type: corev1.LimitTypePod,
+				Max:  corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("150m")},
+			}},
+		},
+	}
+
+	p := &corev1.Pod{
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{
+				{Name: "step1"},
+				{Name: "step2"},
+			},
+		},
+	}
+
+	transform := NewTransformer(context.Background(), "foo", getLister(t, limitRange))
+	got, err := transform(p)
+	if err != nil {
+		t.Fatalf("transform() returned error: %v", err)
+	}
+
+	want := resource.MustParse("75m")
+	for _, c := range got.Spec.Containers {
+		limit := c.Resources.Limits[corev1.ResourceCPU]
+		if limit.Cmp(want) != 0 {
+			t.Errorf("container %q: cpu limit = %s, want %s", c.Name, limit.String(), want.String())
+		}
+	}
+}
+
+// TestNewTransformerPodAggregateBoundsRejectsLimitBelowRequest guards against
+// enforcePodAggregateBounds scaling a pod's limits down to fit a LimitTypePod Max without
+// checking the result against each container's own request: naively applying the same ratio to
+// every container can drop a high-request container's limit below its own request, which the
+// apiserver would reject outright.
+func TestNewTransformerPodAggregateBoundsRejectsLimitBelowRequest(t *testing.T) {
+	limitRange := &corev1.LimitRange{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "foo", Name: "limitrange"},
+		Spec: corev1.LimitRangeSpec{
+			Limits: []corev1.LimitRangeItem{{
+				Type: corev1.LimitTypePod,
+				Max:  corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("100m")},
+			}},
+		},
+	}
+
+	p := &corev1.Pod{
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{
+				{
+					Name: "main",
+					Resources: corev1.ResourceRequirements{
+						Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("190m")},
+						Limits:   corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("200m")},
+					},
+				},
+				{
+					Name: "sidecar",
+					Resources: corev1.ResourceRequirements{
+						Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("5m")},
+						Limits:   corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("5m")},
+					},
+				},
+			},
+		},
+	}
+
+	transform := NewTransformer(context.Background(), "foo", getLister(t, limitRange))
+	if _, err := transform(p); err == nil {
+		t.Fatal("transform() returned no error, want an error since main's limit would drop below its own request")
+	}
+}
+
+// TestResolveRatioBoundary exercises resolveRatio right at the Min/Max boundary, since that's
+// where an off-by-one Cmp direction would silently flip from "adjust" to "reject" or vice versa.
+func TestResolveRatioBoundary(t *testing.T) {
+	cpu := func(s string) resource.Quantity { return resource.MustParse(s) }
+
+	tests := []struct {
+		name                   string
+		request, limit         resource.Quantity
+		maxRatio, min, max     resource.Quantity
+		wantRequest, wantLimit resource.Quantity
+		wantOK                 bool
+	}{
+		{
+			name:        "already within ratio is left untouched",
+			request:     cpu("100m"), limit: cpu("200m"), maxRatio: cpu("4"),
+			min:         cpu("10m"), max: cpu("1"),
+			wantRequest: cpu("100m"), wantLimit: cpu("200m"), wantOK: true,
+		},
+		{
+			name:        "raising the request exactly meets max",
+			request:     cpu("100m"), limit: cpu("1000m"), maxRatio: cpu("4"),
+			min:         cpu("10m"), max: cpu("250m"),
+			wantRequest: cpu("250m"), wantLimit: cpu("1000m"), wantOK: true,
+		},
+		{
+			name:        "raising the request would exceed max, so the limit is lowered to the min boundary",
+			request:     cpu("100m"), limit: cpu("1000m"), maxRatio: cpu("4"),
+			min:         cpu("400m"), max: cpu("200m"),
+			wantRequest: cpu("100m"), wantLimit: cpu("400m"), wantOK: true,
+		},
+		{
+			name:    "neither adjustment fits within min/max",
+			request: cpu("100m"), limit: cpu("1000m"), maxRatio: cpu("4"),
+			min:     cpu("500m"), max: cpu("200m"),
+			wantOK:  false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotRequest, gotLimit, ok := resolveRatio(tt.request, tt.limit, tt.maxRatio, tt.min, tt.max)
+			if ok != tt.wantOK {
+				t.Fatalf("resolveRatio() ok = %v, want %v", ok, tt.wantOK)
+			}
+			if !ok {
+				return
+			}
+			if gotRequest.Cmp(tt.wantRequest) != 0 {
+				t.Errorf("resolveRatio() request = %s, want %s", gotRequest.String(), tt.wantRequest.String())
+			}
+			if gotLimit.Cmp(tt.wantLimit) != 0 {
+				t.Errorf("resolveRatio() limit = %s, want %s", gotLimit.String(), tt.wantLimit.String())
+			}
+		})
+	}
+}
+
+// TestNewTransformerSidecarInitContainerSharesAppDefault verifies that a native sidecar (an
+// init container with RestartPolicy: Always) is counted against the app-container request
+// budget, since it runs for the pod's whole lifetime alongside the app containers, rather than
+// getting the full (undivided) init-container default meant for containers that run and exit
+// before the app containers start.
+func TestNewTransformerSidecarInitContainerSharesAppDefault(t *testing.T) {
+	alwaysRestart := corev1.ContainerRestartPolicyAlways
+	limitRange := &corev1.LimitRange{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "foo", Name: "limitrange"},
+		Spec: corev1.LimitRangeSpec{
+			Limits: []corev1.LimitRangeItem{{
+				Type:           corev1.LimitTypeContainer,
+				DefaultRequest: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("100m")},
+			}},
+		},
+	}
+
+	p := &corev1.Pod{
+		Spec: corev1.PodSpec{
+			InitContainers: []corev1.Container{
+				{Name: "sidecar", RestartPolicy: &alwaysRestart},
+			},
+			Containers: []corev1.Container{
+				{Name: "app"},
+			},
+		},
+	}
+
+	transform := NewTransformer(context.Background(), "foo", getLister(t, limitRange))
+	got, err := transform(p)
+	if err != nil {
+		t.Fatalf("transform() returned error: %v", err)
+	}
+
+	want := resource.MustParse("50m")
+	sidecarRequest := got.Spec.InitContainers[0].Resources.Requests[corev1.ResourceCPU]
+	if sidecarRequest.Cmp(want) != 0 {
+		t.Errorf("sidecar init container cpu request = %s, want %s (app-container default, shared with 1 app container)", sidecarRequest.String(), want.String())
+	}
+	appRequest := got.Spec.Containers[0].Resources.Requests[corev1.ResourceCPU]
+	if appRequest.Cmp(want) != 0 {
+		t.Errorf("app container cpu request = %s, want %s", appRequest.String(), want.String())
+	}
+}
+
+// TestNewValidatorReportsMinViolation exercises NewValidator's public entry point: a pod whose
+// container request is below the namespace's LimitTypeContainer Min should be reported with
+// exactly one field.Error, pointing at that container's request.
+func TestNewValidatorReportsMinViolation(t *testing.T) {
+	limitRange := &corev1.LimitRange{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "foo", Name: "limitrange"},
+		Spec: corev1.LimitRangeSpec{
+			Limits: []corev1.LimitRangeItem{{
+				Type: corev1.LimitTypeContainer,
+				Min:  corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("100m")},
+			}},
+		},
+	}
+
+	p := &corev1.Pod{
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{
+				Name: "app",
+				Resources: corev1.ResourceRequirements{
+					Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("10m")},
+				},
+			}},
+		},
+	}
+
+	validate := NewValidator(context.Background(), "foo", getLister(t, limitRange))
+	errs, err := validate(p)
+	if err != nil {
+		t.Fatalf("validate() returned error: %v", err)
+	}
+	if len(errs) != 1 {
+		t.Fatalf("got %d validation errors, want 1: %v", len(errs), errs)
+	}
+	wantField := "spec.containers[0].resources.requests.cpu"
+	if got := errs[0].Field; got != wantField {
+		t.Errorf("errs[0].Field = %q, want %q", got, wantField)
+	}
+}
+
+// TestNewPVCTransformerClampsStorage exercises NewPVCTransformer's public entry point: a PVC
+// with no explicit storage request picks up DefaultRequest, and one above Max gets clamped
+// down to Max.
+func TestNewPVCTransformerClampsStorage(t *testing.T) {
+	limitRange := &corev1.LimitRange{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "foo", Name: "limitrange"},
+		Spec: corev1.LimitRangeSpec{
+			Limits: []corev1.LimitRangeItem{{
+				Type:           corev1.LimitTypePersistentVolumeClaim,
+				Min:            corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("1Gi")},
+				Max:            corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("10Gi")},
+				DefaultRequest: corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("2Gi")},
+			}},
+		},
+	}
+
+	transform := NewPVCTransformer(context.Background(), "foo", getLister(t, limitRange))
+
+	noRequest := &corev1.PersistentVolumeClaim{}
+	got, err := transform(noRequest)
+	if err != nil {
+		t.Fatalf("transform() returned error: %v", err)
+	}
+	storage := got.Spec.Resources.Requests[corev1.ResourceStorage]
+	if want := resource.MustParse("2Gi"); storage.Cmp(want) != 0 {
+		t.Errorf("storage request = %s, want default %s", storage.String(), want.String())
+	}
+
+	tooBig := &corev1.PersistentVolumeClaim{
+		Spec: corev1.PersistentVolumeClaimSpec{
+			Resources: corev1.VolumeResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("50Gi")},
+			},
+		},
+	}
+	got, err = transform(tooBig)
+	if err != nil {
+		t.Fatalf("transform() returned error: %v", err)
+	}
+	storage = got.Spec.Resources.Requests[corev1.ResourceStorage]
+	if want := resource.MustParse("10Gi"); storage.Cmp(want) != 0 {
+		t.Errorf("storage request = %s, want clamped max %s", storage.String(), want.String())
+	}
+}
+
+// TestGetVirtualLimitRangeMerge covers aggregation across multiple LimitRange objects of the
+// same type: Min/Max/MaxLimitRequestRatio must take the most restrictive value, Default and
+// DefaultRequest take whichever LimitRange sets them first, and the source objects (as returned
+// by the lister) must come out unmutated since they're shared, cached objects.
+func TestGetVirtualLimitRangeMerge(t *testing.T) {
+	first := &corev1.LimitRange{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "foo", Name: "first"},
+		Spec: corev1.LimitRangeSpec{
+			Limits: []corev1.LimitRangeItem{{
+				Type:                 corev1.LimitTypeContainer,
+				Min:                  corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("10m")},
+				Max:                  corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("500m")},
+				Default:              corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("100m")},
+				MaxLimitRequestRatio: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("10")},
+			}},
+		},
+	}
+	second := &corev1.LimitRange{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "foo", Name: "second"},
+		Spec: corev1.LimitRangeSpec{
+			Limits: []corev1.LimitRangeItem{{
+				Type:                 corev1.LimitTypeContainer,
+				Min:                  corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("50m")},
+				Max:                  corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("300m")},
+				Default:              corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("150m")},
+				MaxLimitRequestRatio: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("4")},
+			}},
+		},
+	}
+	firstBefore := first.DeepCopy()
+	secondBefore := second.DeepCopy()
+
+	virtual, err := getVirtualLimitRange(context.Background(), "foo", getLister(t, first, second))
+	if err != nil {
+		t.Fatalf("getVirtualLimitRange() returned error: %v", err)
+	}
+	if len(virtual.Spec.Limits) != 1 {
+		t.Fatalf("got %d merged items, want 1", len(virtual.Spec.Limits))
+	}
+	item := virtual.Spec.Limits[0]
+
+	if got, want := item.Min[corev1.ResourceCPU], resource.MustParse("50m"); got.Cmp(want) != 0 {
+		t.Errorf("Min = %s, want %s (highest of the two)", got.String(), want.String())
+	}
+	if got, want := item.Max[corev1.ResourceCPU], resource.MustParse("300m"); got.Cmp(want) != 0 {
+		t.Errorf("Max = %s, want %s (lowest of the two)", got.String(), want.String())
+	}
+	if got, want := item.MaxLimitRequestRatio[corev1.ResourceCPU], resource.MustParse("4"); got.Cmp(want) != 0 {
+		t.Errorf("MaxLimitRequestRatio = %s, want %s (lowest of the two)", got.String(), want.String())
+	}
+	if got, want := item.Default[corev1.ResourceCPU], resource.MustParse("100m"); got.Cmp(want) != 0 {
+		t.Errorf("Default = %s, want %s (first LimitRange to set it)", got.String(), want.String())
+	}
+
+	if diff := cmp.Diff(firstBefore, first); diff != "" {
+		t.Errorf("first LimitRange was mutated by the merge (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(secondBefore, second); diff != "" {
+		t.Errorf("second LimitRange was mutated by the merge (-want +got):\n%s", diff)
+	}
+}